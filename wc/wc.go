@@ -2,58 +2,148 @@
 
 //	NAME
 //	 	wc - count lines, words, and characters
-//	
+//
 //	SYNOPSIS
-//		wc [ -lwceb ] [ file ... ]
-//	
+//		wc [ -lwcebo ] [ -U ] [ -j N ] [ -json | -0 ] [ file ... ]
+//
 //	DESCRIPTION
 //		Wc writes to standard output a tally of lines, words, and
 //		characters found in each file, assumed to be text in UTF
 //		format.  If no files are named, standard input is read.  One
 //		line is output per file.  If several files are specified, an
 //		additional line is written giving totals.
-//	
+//
 //		`Words' are maximal sequences of characters separated by
 //		blanks, tabs and newlines.
-//	
+//
 //		Counts are output in the same order as the listing of the
-//		option letters lwceb; select lines, words, UTF characters,
-//		erroneously-encoded characters, and bytes, respectively.  If
-//		no options are given, lines, words, and characters are
-//		counted.
-//	
+//		option letters lwcebo; select lines, words, UTF characters,
+//		erroneously-encoded characters, bytes, and non-canonical
+//		(overlong) UTF codes, respectively.  If no options are given,
+//		lines, words, and characters are counted.
+//
+//		The -o option counts non-canonical runes: UTF sequences that
+//		are syntactically well-formed but encode their rune using more
+//		bytes than necessary (e.g. hex c1,80 instead of hex 40), along
+//		with encoded surrogates (U+D800-U+DFFF).  These are counted as
+//		runes, not as erroneously-encoded characters.
+//
+//		The -U option makes wc split lines and words on full Unicode
+//		whitespace instead of just ASCII space, tab, and newline: any
+//		code point for which unicode.IsSpace is true separates words,
+//		and \n, \v, \f, \r, NEL (U+0085), LINE SEPARATOR (U+2028), and
+//		PARAGRAPH SEPARATOR (U+2029) end a line.
+//
+//		The -j option counts multiple files concurrently, using N
+//		worker goroutines; output is still printed in the order the
+//		files were given.  If N is 0 or omitted, it defaults to
+//		min(runtime.NumCPU(), number of files).
+//
+//		-json and -0 replace the fixed-width column report with a
+//		machine-readable one, for scripting; they're mutually
+//		exclusive.  -json writes one JSON object per file (and one
+//		for "total"), always with all of file, lines, words, chars,
+//		errors, and bytes, regardless of which -lwceb flags were
+//		given.  -0 keeps today's columns but ends each record with
+//		NUL instead of newline, and never quotes the filename, so it
+//		composes with "find -print0 | xargs -0 wc -0".
+//
 //	BUGS
-//		The Unicode Standard has many blank characters scattered
-//		through it, but wc looks for only ASCII space, tab, and new-
-//		line.
-//	
-//		Wc should have options to count suboptimal UTF codes and
-//		bytes that cannot occur in any UTF code.
+//		Without -U, wc looks for only ASCII space, tab, and newline,
+//		even though the Unicode Standard has many more blank
+//		characters scattered through it.
 
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 const NBUF = 8 * 1024
 
 // Command-line flags
 var (
-	lflag = flag.Bool("l", false, "print number of lines")
-	wflag = flag.Bool("w", false, "print number of words")
-	cflag = flag.Bool("c", false, "print number of characters")
-	eflag = flag.Bool("e", false, "print number of erroneously-encoded characters")
-	bflag = flag.Bool("b", false, "print number of bytes")
+	lflag    = flag.Bool("l", false, "print number of lines")
+	wflag    = flag.Bool("w", false, "print number of words")
+	cflag    = flag.Bool("c", false, "print number of characters")
+	eflag    = flag.Bool("e", false, "print number of erroneously-encoded characters")
+	bflag    = flag.Bool("b", false, "print number of bytes")
+	oflag    = flag.Bool("o", false, "print number of non-canonical (overlong) UTF codes")
+	Uflag    = flag.Bool("U", false, "split lines and words on Unicode whitespace, not just ASCII")
+	jflag    = new(jobsFlag)
+	jsonflag = flag.Bool("json", false, "print one JSON object per file instead of columns")
+	zflag    = flag.Bool("0", false, "NUL-terminate each report record instead of newline-terminating it")
 )
 
+func init() {
+	flag.Var(jflag, "j", "count files concurrently using N worker goroutines (0 or no value: auto)")
+}
+
+// jobsFlag is the value behind -j.  It implements flag.Value and the
+// unexported boolFlag interface flag.Var looks for, so "-j" alone (no
+// number attached) is accepted just like a bool flag and means "auto".
+type jobsFlag struct {
+	n   int
+	set bool
+}
+
+func (j *jobsFlag) String() string {
+	if j == nil {
+		return "0"
+	}
+	return strconv.Itoa(j.n)
+}
+
+func (j *jobsFlag) Set(s string) error {
+	if s == "true" { // -j given with no explicit number
+		j.n = 0
+	} else {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		j.n = n
+	}
+	j.set = true
+	return nil
+}
+
+func (j *jobsFlag) IsBoolFlag() bool { return true }
+
 type counter struct {
-	lines, words, chars, errors, bytes uint64
+	lines, words, chars, errors, bytes, overlongs uint64
+
+	// leadBits and overlong track the multi-byte sequence currently
+	// being decoded, so a later continuation byte can be judged against
+	// the lead byte that started it; see the count state-machine notes.
+	leadBits byte
+	overlong bool
 }
 
-func report(c *counter, name string) {
+// formatter turns a counter's tally for one file into the text of a
+// report record.  report routes every record through one, so adding a
+// format (e.g. CSV) means adding a formatter, not touching count.
+type formatter interface {
+	format(c *counter, name string) string
+}
+
+// columnFormatter is the original fixed-width report: one %7d column
+// per selected -lwcebo flag, terminated by term instead of always "\n"
+// so -0 can reuse it with a NUL terminator.
+type columnFormatter struct {
+	term string
+}
+
+func (cf columnFormatter) format(c *counter, name string) string {
 	var s string
 	if *lflag {
 		s += fmt.Sprintf("%7d", c.lines)
@@ -70,21 +160,57 @@ func report(c *counter, name string) {
 	if *bflag {
 		s += fmt.Sprintf("%7d", c.bytes)
 	}
-	s += " " + name + "\n"
-	os.Stdout.WriteString(s)
+	if *oflag {
+		s += fmt.Sprintf("%7d", c.overlongs)
+	}
+	s += " " + name + cf.term
+	return s
+}
+
+// jsonFormatter emits one JSON object per record with a fixed set of
+// fields, regardless of which -lwceb flags were passed, so downstream
+// tools can rely on a stable schema.
+type jsonFormatter struct{}
+
+func (jsonFormatter) format(c *counter, name string) string {
+	b, _ := json.Marshal(struct {
+		File   string `json:"file"`
+		Lines  uint64 `json:"lines"`
+		Words  uint64 `json:"words"`
+		Chars  uint64 `json:"chars"`
+		Errors uint64 `json:"errors"`
+		Bytes  uint64 `json:"bytes"`
+	}{name, c.lines, c.words, c.chars, c.errors, c.bytes})
+	return string(b) + "\n"
+}
+
+// out is the formatter report uses; main picks it based on -json/-0.
+var out formatter = columnFormatter{term: "\n"}
+
+func report(c *counter, name string) {
+	os.Stdout.WriteString(out.format(c, name))
 }
 
 // How it works.  Start in statesp.  Each time we read a character,
 // increment various counts, and do state transitions according to the
 // following table.  If we're not in statesp or statewd when done, the
 // file ends with a partial rune.
+//
+// To detect overlong encodings (-o), count also remembers the lead byte
+// of whichever multi-byte sequence is in progress in n.leadBits, set
+// whenever a lead byte is consumed (the AC2/AC2X/AC3/AC3X/AC4/AC4X
+// actions).  AC2R, AC3R and AWDR consult it against the continuation
+// byte they're given to flag a pending overlong or surrogate rune in
+// n.overlong; AWDR is where every multi-byte rune finally completes, so
+// that's where the flag is turned into a count and cleared.
 //        |                character
-//  state |09,20| 0a  |00-7f|80-bf|c0-df|e0-ef|f0-ff
-// -------+-----+-----+-----+-----+-----+-----+-----
-// statesp|ASP  |ASPN |AWDW |AWDWX|AC2W |AC3W |AWDWX
-// statewd|ASP  |ASPN |AWD  |AWDX |AC2  |AC3  |AWDX
-// statec2|ASPX |ASPNX|AWDX |AWDR |AC2X |AC3X |AWDX
-// statec3|ASPX |ASPNX|AWDX |AC2R |AC2X |AC3X |AWDX
+//  state |09,20| 0a  |00-7f|80-bf|c0-df|e0-ef|f0-f7|f8-ff
+// -------+-----+-----+-----+-----+-----+-----+-----+-----
+// statesp|ASP  |ASPN |AWDW |AWDWX|AC2W |AC3W |AC4W |AWDWX
+// statewd|ASP  |ASPN |AWD  |AWDX |AC2  |AC3  |AC4  |AWDX
+// statec2|ASPX |ASPNX|AWDX |AWDR |AC2X |AC3X |AC4X |AWDX
+// statec3|ASPX |ASPNX|AWDX |AC2R |AC2X |AC3X |AC4X |AWDX
+// statec4|ASPX |ASPNX|AWDX |AC3R |AC2X |AC3X |AC4X |AWDX
 
 const ( // actions
 	AC2   = iota // enter statec2
@@ -92,8 +218,12 @@ const ( // actions
 	AC2W         // enter statec2, count a word
 	AC2X         // enter statec2, count a bad rune
 	AC3          // enter statec3
+	AC3R         // enter statec3, don't count a rune
 	AC3W         // enter statec3, count a word
 	AC3X         // enter statec3, count a bad rune
+	AC4          // enter statec4
+	AC4W         // enter statec4, count a word
+	AC4X         // enter statec4, count a bad rune
 	ASP          // enter statesp
 	ASPN         // enter statesp, count a newline
 	ASPNX        // enter statesp, count a newline, count a bad rune
@@ -136,7 +266,7 @@ var statesp = [256]byte{ // looking for the start of a word
 	AC2W, AC2W, AC2W, AC2W, AC2W, AC2W, AC2W, AC2W, // d8-df
 	AC3W, AC3W, AC3W, AC3W, AC3W, AC3W, AC3W, AC3W, // e0-e7
 	AC3W, AC3W, AC3W, AC3W, AC3W, AC3W, AC3W, AC3W, // e8-ef
-	AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, // f0-f7
+	AC4W, AC4W, AC4W, AC4W, AC4W, AC4W, AC4W, AC4W, // f0-f7
 	AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, AWDWX, // f8-ff
 }
 
@@ -171,7 +301,7 @@ var statewd = [256]byte{ // looking for the next character in a word
 	AC2, AC2, AC2, AC2, AC2, AC2, AC2, AC2, // d8-df
 	AC3, AC3, AC3, AC3, AC3, AC3, AC3, AC3, // e0-e7
 	AC3, AC3, AC3, AC3, AC3, AC3, AC3, AC3, // e8-ef
-	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f0-f7
+	AC4, AC4, AC4, AC4, AC4, AC4, AC4, AC4, // f0-f7
 	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f8-ff
 }
 
@@ -206,7 +336,7 @@ var statec2 = [256]byte{ // looking for 10xxxxxx to complete a rune
 	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // d8-df
 	AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, // e0-e7
 	AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, // e8-ef
-	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f0-f7
+	AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, // f0-f7
 	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f8-ff
 }
 
@@ -233,7 +363,7 @@ var statec3 = [256]byte{ // looking for 10xxxxxx,10xxxxxx to complete a rune
 	AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, // 98-9f
 	AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, // a0-a7
 	AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, // a8-af
-	AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, // b0-b7 
+	AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, // b0-b7
 	AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, AC2R, // b8-bf
 	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // c0-c7
 	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // c8-cf
@@ -241,7 +371,42 @@ var statec3 = [256]byte{ // looking for 10xxxxxx,10xxxxxx to complete a rune
 	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // d8-df
 	AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, // e0-e7
 	AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, // e8-ef
-	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f0-f7
+	AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, // f0-f7
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f8-ff
+}
+
+var statec4 = [256]byte{ // looking for 10xxxxxx,10xxxxxx,10xxxxxx to complete a rune
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 00-07
+	AWDX, ASPX, ASPNX, AWDX, AWDX, AWDX, AWDX, AWDX, // 08-0f
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 10-17
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 18-1f
+	ASPX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 20-27
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 28-2f
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 30-37
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 38-3f
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 40-47
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 48-4f
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 50-57
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 58-5f
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 60-67
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 68-6f
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 70-77
+	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // 78-7f
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // 80-87
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // 88-8f
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // 90-97
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // 98-9f
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // a0-a7
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // a8-af
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // b0-b7
+	AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, AC3R, // b8-bf
+	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // c0-c7
+	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // c8-cf
+	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // d0-d7
+	AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, AC2X, // d8-df
+	AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, // e0-e7
+	AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, AC3X, // e8-ef
+	AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, AC4X, // f0-f7
 	AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, AWDX, // f8-ff
 }
 
@@ -253,6 +418,8 @@ func count(n *counter, f *os.File) {
 	n.chars = 0
 	n.errors = 0
 	n.bytes = 0
+	n.overlongs = 0
+	n.overlong = false
 	for {
 		nr, er := f.Read(buf[:])
 		if nr == 0 {
@@ -268,23 +435,49 @@ func count(n *counter, f *os.File) {
 			switch state[buf[i]] {
 			case AC2:
 				state = statec2[:]
+				n.leadBits, n.overlong = buf[i], false
 			case AC2R:
 				state = statec2[:]
 				n.chars--
+				if (n.leadBits == 0xe0 && buf[i] < 0xa0) || (n.leadBits == 0xed && buf[i] >= 0xa0) {
+					n.overlong = true
+				}
 			case AC2W:
 				state = statec2[:]
 				n.words++
+				n.leadBits, n.overlong = buf[i], false
 			case AC2X:
 				state = statec2[:]
 				n.errors++
+				n.leadBits, n.overlong = buf[i], false
 			case AC3:
 				state = statec3[:]
+				n.leadBits, n.overlong = buf[i], false
+			case AC3R:
+				state = statec3[:]
+				n.chars--
+				if n.leadBits == 0xf0 && buf[i] < 0x90 {
+					n.overlong = true
+				}
 			case AC3W:
 				state = statec3[:]
 				n.words++
+				n.leadBits, n.overlong = buf[i], false
 			case AC3X:
 				state = statec3[:]
 				n.errors++
+				n.leadBits, n.overlong = buf[i], false
+			case AC4:
+				state = statec4[:]
+				n.leadBits, n.overlong = buf[i], false
+			case AC4W:
+				state = statec4[:]
+				n.words++
+				n.leadBits, n.overlong = buf[i], false
+			case AC4X:
+				state = statec4[:]
+				n.errors++
+				n.leadBits, n.overlong = buf[i], false
 			case ASP:
 				state = statesp[:]
 			case ASPN:
@@ -294,14 +487,23 @@ func count(n *counter, f *os.File) {
 				state = statesp[:]
 				n.lines++
 				n.errors++
+				n.overlong = false
 			case ASPX:
 				state = statesp[:]
 				n.errors++
+				n.overlong = false
 			case AWD:
 				state = statewd[:]
 			case AWDR:
 				state = statewd[:]
 				n.chars--
+				if n.leadBits == 0xc0 || n.leadBits == 0xc1 {
+					n.overlong = true
+				}
+				if n.overlong {
+					n.overlongs++
+					n.overlong = false
+				}
 			case AWDW:
 				state = statewd[:]
 				n.words++
@@ -309,45 +511,269 @@ func count(n *counter, f *os.File) {
 				state = statewd[:]
 				n.words++
 				n.errors++
+				n.overlong = false
 			case AWDX:
 				state = statewd[:]
 				n.errors++
+				n.overlong = false
 			}
 		}
 	}
+	if &state[0] != &statesp[0] && &state[0] != &statewd[0] {
+		n.errors++
+	}
 }
 
-func main() {
-	n := new(counter)
-	t := new(counter)
+// isUnicodeLineBreak reports whether r ends a line under -U, which
+// recognizes the usual ASCII line terminators plus the Unicode ones
+// (NEL, LINE SEPARATOR, PARAGRAPH SEPARATOR).
+func isUnicodeLineBreak(r rune) bool {
+	switch r {
+	case '\n', '\v', '\f', '\r', '\u0085', '\u2028', '\u2029':
+		return true
+	}
+	return false
+}
+
+// leadLen reports how many bytes the UTF-8 sequence starting with b0
+// ought to occupy: 1 for ASCII and for bytes that can't start a valid
+// sequence (stray continuation bytes, f8-ff), 2/3/4 for multi-byte
+// leads (c0-c1 included, even though those leads are only ever valid
+// as overlong 2-byte encodings).
+func leadLen(b0 byte) int {
+	switch {
+	case b0 < 0x80:
+		return 1
+	case b0 < 0xC0:
+		return 1
+	case b0 < 0xE0:
+		return 2
+	case b0 < 0xF0:
+		return 3
+	case b0 < 0xF8:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// decodeRuneLenient decodes one UTF-8 sequence from the front of buf
+// the way the DFA's count does, not the way utf8.DecodeRune does:
+// overlong 2/3/4-byte encodings and encoded surrogates (U+D800-U+DFFF)
+// are accepted as runes rather than rejected, with overlong reporting
+// whether the encoding was non-canonical.  This lets -U honor -o
+// instead of just feeding those sequences to utf8.DecodeRune and
+// turning them into plain errors.  buf must hold at least leadLen(buf[0])
+// bytes; otherwise, like utf8.DecodeRune, it reports (RuneError, 1).
+func decodeRuneLenient(buf []byte) (r rune, size int, overlong bool) {
+	b0 := buf[0]
+	need := leadLen(b0)
+	if need > len(buf) {
+		return utf8.RuneError, 1, false
+	}
+	if need == 1 {
+		if b0 < 0x80 {
+			return rune(b0), 1, false
+		}
+		return utf8.RuneError, 1, false // stray continuation byte or f8-ff
+	}
+	for _, b := range buf[1:need] {
+		if b < 0x80 || b > 0xBF {
+			return utf8.RuneError, 1, false
+		}
+	}
+	switch need {
+	case 2:
+		r = rune(b0&0x1F)<<6 | rune(buf[1]&0x3F)
+		overlong = b0 == 0xC0 || b0 == 0xC1
+	case 3:
+		r = rune(b0&0x0F)<<12 | rune(buf[1]&0x3F)<<6 | rune(buf[2]&0x3F)
+		overlong = (b0 == 0xE0 && buf[1] < 0xA0) || (r >= 0xD800 && r <= 0xDFFF)
+	case 4:
+		r = rune(b0&0x07)<<18 | rune(buf[1]&0x3F)<<12 | rune(buf[2]&0x3F)<<6 | rune(buf[3]&0x3F)
+		overlong = b0 == 0xF0 && buf[1] < 0x90
+	}
+	return r, need, overlong
+}
+
+// countUnicode is the -U counterpart of count: instead of the fast
+// byte-indexed DFA above, it decodes runes with decodeRuneLenient so
+// that unicode.IsSpace, rather than just ASCII space/tab/newline, can
+// decide where words and lines break, while still honoring -o the way
+// the DFA does.  It still reads through the same NBUF buffer via
+// bufio.Reader, it just pays the decoding cost count avoids.
+func countUnicode(n *counter, f *os.File) {
+	br := bufio.NewReaderSize(f, NBUF)
+	n.lines = 0
+	n.words = 0
+	n.chars = 0
+	n.errors = 0
+	n.bytes = 0
+	n.overlongs = 0
+	inWord := false
+	for {
+		buf, peekErr := br.Peek(utf8.UTFMax)
+		if len(buf) == 0 {
+			break
+		}
+		if need := leadLen(buf[0]); need > len(buf) && peekErr != nil {
+			// A multi-byte rune was cut short by EOF; count the
+			// dangling bytes as a single partial-rune error, as the
+			// DFA path does.
+			n.bytes += uint64(len(buf))
+			n.chars++
+			n.errors++
+			br.Discard(len(buf))
+			break
+		}
+		r, size, overlong := decodeRuneLenient(buf)
+		if r == utf8.RuneError && size <= 1 {
+			n.bytes++
+			n.chars++
+			n.errors++
+			if !inWord {
+				n.words++
+				inWord = true
+			}
+			br.Discard(1)
+			continue
+		}
+		br.Discard(size)
+		n.bytes += uint64(size)
+		n.chars++
+		if overlong {
+			n.overlongs++
+		}
+		if isUnicodeLineBreak(r) {
+			n.lines++
+			inWord = false
+		} else if unicode.IsSpace(r) {
+			inWord = false
+		} else if !inWord {
+			n.words++
+			inWord = true
+		}
+	}
+}
+
+// parseArgs rewrites "-j N" (space-separated) into "-j=N" before handing
+// args to the flag package, since jflag's boolFlag-style Set lets bare
+// "-j" through but would otherwise swallow the file argument following
+// a space-separated "-j N".
+func parseArgs(args []string) {
+	rewritten := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "-j" || a == "--j" {
+			if i+1 < len(args) {
+				if _, err := strconv.Atoi(args[i+1]); err == nil {
+					rewritten = append(rewritten, a+"="+args[i+1])
+					i++
+					continue
+				}
+			}
+		}
+		rewritten = append(rewritten, a)
+	}
+	flag.CommandLine.Parse(rewritten)
+}
 
-	flag.Parse()
-	if flag.NFlag() == 0 {
+func main() {
+	parseArgs(os.Args[1:])
+	if !(*lflag || *wflag || *cflag || *eflag || *bflag || *oflag) {
 		*lflag = true
 		*wflag = true
 		*cflag = true
 	}
 
-	if flag.NArg() == 0 {
-		count(n, os.Stdin)
+	if *jsonflag && *zflag {
+		fmt.Fprintln(os.Stderr, "wc: -json and -0 are mutually exclusive")
+		os.Exit(1)
+	}
+	if *jsonflag {
+		out = jsonFormatter{}
+	} else if *zflag {
+		out = columnFormatter{term: "\x00"}
+	}
+
+	doCount := count
+	if *Uflag {
+		doCount = countUnicode
+	}
+
+	nArg := flag.NArg()
+	if nArg == 0 {
+		n := new(counter)
+		doCount(n, os.Stdin)
 		report(n, "")
+		return
 	}
-	for i := 0; i < flag.NArg(); i++ {
+
+	countFile := func(i int) *counter {
 		f, err := os.Open(flag.Arg(i))
-		defer f.Close()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "wc: can't open %s: error %s\n", flag.Arg(i), err)
 			os.Exit(1)
 		}
-		count(n, f)
-		t.lines += n.lines
-		t.words += n.words
-		t.chars += n.chars
-		t.errors += n.errors
-		t.bytes += n.bytes
-		report(n, flag.Arg(i))
+		c := new(counter)
+		doCount(c, f)
+		f.Close()
+		return c
 	}
-	if flag.NArg() > 1 {
+
+	results := make([]*counter, nArg)
+	workers := jobs(nArg)
+	if workers <= 1 {
+		for i := 0; i < nArg; i++ {
+			results[i] = countFile(i)
+		}
+	} else {
+		queue := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range queue {
+					results[i] = countFile(i)
+				}
+			}()
+		}
+		for i := 0; i < nArg; i++ {
+			queue <- i
+		}
+		close(queue)
+		wg.Wait()
+	}
+
+	t := new(counter)
+	for i, c := range results {
+		t.lines += c.lines
+		t.words += c.words
+		t.chars += c.chars
+		t.errors += c.errors
+		t.bytes += c.bytes
+		t.overlongs += c.overlongs
+		report(c, flag.Arg(i))
+	}
+	if nArg > 1 {
 		report(t, "total")
 	}
-}
\ No newline at end of file
+}
+
+// jobs returns the number of worker goroutines main should use to count
+// nArg files, based on -j.  -j not given means no concurrency (1); -j
+// given as 0 or without a value means min(runtime.NumCPU(), nArg).
+func jobs(nArg int) int {
+	if !jflag.set {
+		return 1
+	}
+	n := jflag.n
+	if n <= 0 {
+		n = runtime.NumCPU()
+		if nArg < n {
+			n = nArg
+		}
+	}
+	return n
+}